@@ -4,12 +4,25 @@
 package chistaticmiddleware
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
@@ -26,31 +39,208 @@ type Logger interface {
 // StaticFilePrefix is the URL prefix used to serve static files.
 // CacheDuration is the duration for which the static files are cached.
 //
+// SPAMode enables single-page-application fallback: requests under StaticFilePrefix that
+// don't resolve to an existing file are served IndexFile instead, so client-side routers can
+// handle deep links and page refreshes. A missing path with a file extension still 404s unless
+// the request's Accept header contains "text/html". IndexFile defaults to "index.html" when
+// empty, and BasePath, if set, rewrites the served index's <base href="..."> so the same build
+// can be mounted under different prefixes.
+//
 // The Debug flag enables additional logging for troubleshooting, and Logger is an interface
-// for a custom logging mechanism. If Logger is nil and Debug is true, a default logger is used.
+// for a custom logging mechanism. If Logger and SlogLogger are both nil and Debug is true,
+// slog.Default() is used.
 type Config struct {
 	StaticFS         fs.FS
 	StaticRoot       string
 	StaticFilePrefix string
 	CacheDuration    time.Duration
 
+	SPAMode   bool
+	IndexFile string
+	BasePath  string
+
+	// Precompressed lists content codings (in priority order) to look for as sibling files —
+	// "gzip" tries "<name>.gz", "br" tries "<name>.br", "zstd" tries "<name>.zst" — so
+	// precompressed embedded bundles can be served without paying gzip CPU cost per request.
+	// Empty (the default) disables the lookup entirely.
+	Precompressed []string
+
+	// ETag enables strong ETag generation and conditional GET handling for files served from
+	// StaticFS. On first access a file's bytes are read once, a strong ETag is computed and
+	// cached alongside its size and content type, and subsequent requests are served from that
+	// cache while honoring If-None-Match with a 304. MaxCachedBytes bounds the cache's total
+	// size; once exceeded, entries are evicted least-recently-used first. Zero means unbounded.
+	// A file larger than MaxCachedBytes on its own is never cached and is read on every request.
+	ETag           bool
+	MaxCachedBytes int64
+
+	// SlogLogger, if set, is used for debug output instead of Logger, emitting structured
+	// attributes (path, method, status, bytes, duration, encoding, cache_hit) rather than a
+	// formatted string. r.Context() is threaded through so records pick up any values a parent
+	// middleware attached (request id, trace id). Logger is kept alongside it for back-compat.
+	SlogLogger *slog.Logger
+
+	// Hidden lists file names or glob patterns (path.Match syntax, e.g. "*.map", ".env")
+	// matched against each path segment; a match always 404s, regardless of DotfilePolicy.
+	// DotfilePolicy controls segments starting with "." that aren't matched by Hidden: Allow
+	// (the default) serves them normally, Ignore 404s them, and Deny responds 403.
+	Hidden        []string
+	DotfilePolicy DotfilePolicy
+
+	// Overlays are searched, in order, ahead of StaticFS before falling back to it — the
+	// primary use case is passing []fs.FS{os.DirFS("./web/dist")} in development so on-disk
+	// edits are picked up without recompiling, while the embedded StaticFS remains the
+	// fallback for anything not present on disk.
+	Overlays []fs.FS
+
+	// IndexTransform, when TemplateData or CSPPolicy is set, renders files matching its Paths
+	// (defaulting to "index.html") as a text/template on every request instead of serving them
+	// verbatim, letting a single embedded SPA build be reconfigured per environment without a
+	// rebuild. TemplateData, if set, is called per request and its result exposed as .Config;
+	// a fresh CSP nonce is generated per request and exposed as .Nonce, and both are available
+	// to the template via the "js" func for safe embedding, e.g.
+	// "window.__CONFIG__ = {{ .Config | js }}". CSPPolicy, if set, has "{nonce}" substituted
+	// with the generated nonce and is emitted as the Content-Security-Policy header. Parsed
+	// templates are cached per path, keyed by the source file's mtime and size, so repeated
+	// requests only pay for template execution.
+	IndexTransform IndexTransformConfig
+
 	Debug  bool
 	Logger Logger
 }
 
+// IndexTransformConfig configures the per-request index.html template pipeline. See
+// Config.IndexTransform for details.
+type IndexTransformConfig struct {
+	TemplateData func(*http.Request) any
+	CSPPolicy    string
+	Paths        []string
+}
+
+// enabled reports whether the transform pipeline should run at all.
+func (c IndexTransformConfig) enabled() bool {
+	return c.TemplateData != nil || c.CSPPolicy != ""
+}
+
+// matches reports whether relPath is one of the configured Paths, defaulting to "index.html".
+func (c IndexTransformConfig) matches(relPath string) bool {
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = []string{"index.html"}
+	}
+	for _, p := range paths {
+		if p == relPath {
+			return true
+		}
+	}
+	return false
+}
+
+// DotfilePolicy controls how requests for dotfiles (path segments starting with ".") are
+// handled.
+type DotfilePolicy string
+
+const (
+	DotfilePolicyAllow  DotfilePolicy = "allow"
+	DotfilePolicyIgnore DotfilePolicy = "ignore"
+	DotfilePolicyDeny   DotfilePolicy = "deny"
+)
+
 // StaticMiddleware struct holds the configuration for a middleware instance.
 type StaticMiddleware struct {
 	config Config
+
+	etagCache     *etagCache
+	templateCache *templateCache
 }
 
 // NewStaticMiddleware initializes a new StaticMiddleware instance with the provided configuration.
-// If the Debug flag is set and no custom Logger is provided, it defaults to the standard library's logger.
+// If the Debug flag is set and neither Logger nor SlogLogger is provided, it defaults to slog.Default().
 func NewStaticMiddleware(config Config) *StaticMiddleware {
-	if config.Debug && config.Logger == nil {
-		config.Logger = log.New(os.Stdout, "DEBUG: ", log.LstdFlags)
+	if config.Debug && config.Logger == nil && config.SlogLogger == nil {
+		config.SlogLogger = slog.Default()
 	}
 
-	return &StaticMiddleware{config: config}
+	m := &StaticMiddleware{config: config}
+	if config.ETag {
+		m.etagCache = newEtagCache(config.MaxCachedBytes)
+	}
+	if config.IndexTransform.enabled() {
+		m.templateCache = newTemplateCache()
+	}
+
+	return m
+}
+
+// NewStaticMiddlewareDev wraps NewStaticMiddleware, adding devDir (e.g. "./web/dist") as a disk
+// overlay ahead of config.StaticFS and any configured Overlays, so local edits are picked up
+// without recompiling. It's meant to be wired up behind a development-only environment
+// variable, leaving config.StaticFS (typically an embed.FS) as the production fallback.
+func NewStaticMiddlewareDev(config Config, devDir string) *StaticMiddleware {
+	config.Overlays = append([]fs.FS{os.DirFS(devDir)}, config.Overlays...)
+	return NewStaticMiddleware(config)
+}
+
+// debugf logs a free-form debug message via SlogLogger if configured, falling back to Logger.
+// It is a no-op unless Debug is enabled.
+func (m *StaticMiddleware) debugf(r *http.Request, format string, args ...interface{}) {
+	if !m.config.Debug {
+		return
+	}
+	if m.config.SlogLogger != nil {
+		m.config.SlogLogger.DebugContext(r.Context(), fmt.Sprintf(format, args...))
+		return
+	}
+	if m.config.Logger != nil {
+		m.config.Logger.Printf(format, args...)
+	}
+}
+
+// logServed logs the outcome of serving a request with structured attributes when SlogLogger
+// is configured, or an equivalent formatted message via Logger otherwise. It is a no-op unless
+// Debug is enabled.
+func (m *StaticMiddleware) logServed(r *http.Request, status, bytes int, duration time.Duration, encoding string, cacheHit bool) {
+	if !m.config.Debug {
+		return
+	}
+	if m.config.SlogLogger != nil {
+		m.config.SlogLogger.LogAttrs(r.Context(), slog.LevelDebug, "served static file",
+			slog.String("path", r.URL.Path),
+			slog.String("method", r.Method),
+			slog.Int("status", status),
+			slog.Int("bytes", bytes),
+			slog.Duration("duration", duration),
+			slog.String("encoding", encoding),
+			slog.Bool("cache_hit", cacheHit),
+		)
+		return
+	}
+	if m.config.Logger != nil {
+		m.config.Logger.Printf("Served static file: path=%s method=%s status=%d bytes=%d duration=%s encoding=%s cache_hit=%t",
+			r.URL.Path, r.Method, status, bytes, duration, encoding, cacheHit)
+	}
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code and byte count
+// written, so serveStaticFiles can log them without the file server's cooperation.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
 }
 
 // Handler sets up the HTTP middleware handler. It serves static files based on the URL path
@@ -60,39 +250,562 @@ func (m *StaticMiddleware) Handler() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if strings.HasPrefix(r.URL.Path, m.config.StaticFilePrefix) {
-				if m.config.Debug {
-					m.config.Logger.Printf("Serving static file: %s", r.URL.Path)
-				}
+				m.debugf(r, "Serving static file: %s", r.URL.Path)
 				m.serveStaticFiles(w, r)
 			} else {
-				if m.config.Debug {
-					m.config.Logger.Printf("Passing request to next handler: %s", r.URL.Path)
-				}
+				m.debugf(r, "Passing request to next handler: %s", r.URL.Path)
 				next.ServeHTTP(w, r)
 			}
 		})
 	}
 }
 
+// baseHrefPattern matches a <base href="..."> tag so it can be rewritten for BasePath.
+var baseHrefPattern = regexp.MustCompile(`<base\s+href="[^"]*"\s*/?>`)
+
+// precompressedSuffixes maps a Precompressed encoding name to the sibling file suffix it looks
+// up in the sub-FS.
+var precompressedSuffixes = map[string]string{
+	"gzip": ".gz",
+	"br":   ".br",
+	"zstd": ".zst",
+}
+
+// acceptsEncoding reports whether acceptEncoding (the raw Accept-Encoding header value) allows
+// encoding: the coding must be listed by name, and a listed coding is acceptable unless it has an
+// explicit q-value of 0, e.g. "br;q=0, gzip" disallows br even though the name appears in the
+// header. Codings absent from the header are not accepted, and a "*" entry matches none.
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), encoding) {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// LayeredFS searches a list of filesystems, in order, returning the first hit. It implements
+// fs.FS, fs.StatFS, fs.ReadDirFS and fs.ReadFileFS so it can stand in anywhere a plain fs.FS is
+// expected, including fs.Sub.
+type LayeredFS struct {
+	layers []fs.FS
+}
+
+// NewLayeredFS returns a LayeredFS that searches layers in order.
+func NewLayeredFS(layers ...fs.FS) *LayeredFS {
+	return &LayeredFS{layers: layers}
+}
+
+func (l *LayeredFS) Open(name string) (fs.File, error) {
+	var err error
+	for _, layer := range l.layers {
+		f, e := layer.Open(name)
+		if e == nil {
+			return f, nil
+		}
+		err = e
+	}
+	return nil, layeredErr("open", name, err)
+}
+
+func (l *LayeredFS) Stat(name string) (fs.FileInfo, error) {
+	var err error
+	for _, layer := range l.layers {
+		info, e := fs.Stat(layer, name)
+		if e == nil {
+			return info, nil
+		}
+		err = e
+	}
+	return nil, layeredErr("stat", name, err)
+}
+
+func (l *LayeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var err error
+	for _, layer := range l.layers {
+		entries, e := fs.ReadDir(layer, name)
+		if e == nil {
+			return entries, nil
+		}
+		err = e
+	}
+	return nil, layeredErr("readdir", name, err)
+}
+
+func (l *LayeredFS) ReadFile(name string) ([]byte, error) {
+	var err error
+	for _, layer := range l.layers {
+		data, e := fs.ReadFile(layer, name)
+		if e == nil {
+			return data, nil
+		}
+		err = e
+	}
+	return nil, layeredErr("readfile", name, err)
+}
+
+// layeredErr wraps the last layer's error, defaulting to fs.ErrNotExist when there were no
+// layers to try.
+func layeredErr(op, name string, err error) error {
+	if err == nil {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// isAssetPath reports whether relPath has an extension that identifies it as a built asset
+// rather than a page route. Any extension counts — the point is to 404 on a missing .pdf or
+// .mp4 just as readily as a missing .js, so bundler and build errors stay visible instead of
+// silently resolving to the SPA shell.
+func isAssetPath(relPath string) bool {
+	return path.Ext(relPath) != ""
+}
+
+// blockedStatus reports the status code that should be returned instead of serving relPath —
+// http.StatusNotFound for a Hidden match or an Ignore-policy dotfile, http.StatusForbidden for
+// a Deny-policy dotfile, or 0 if relPath is not blocked.
+func (m *StaticMiddleware) blockedStatus(relPath string) int {
+	segments := strings.Split(path.Clean(relPath), "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." {
+			continue
+		}
+
+		for _, pattern := range m.config.Hidden {
+			if matched, _ := path.Match(pattern, segment); matched {
+				return http.StatusNotFound
+			}
+		}
+	}
+
+	for _, segment := range segments {
+		if segment != "." && strings.HasPrefix(segment, ".") {
+			switch m.config.DotfilePolicy {
+			case DotfilePolicyDeny:
+				return http.StatusForbidden
+			case DotfilePolicyIgnore:
+				return http.StatusNotFound
+			}
+		}
+	}
+	return 0
+}
+
 // serveStaticFiles is responsible for serving the static files. It creates a sub-filesystem
 // from the configured static root directory and serves the files using the standard library's
 // file server.
 func (m *StaticMiddleware) serveStaticFiles(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	var cacheHit bool
+	defer func() {
+		m.logServed(r, rec.status, rec.bytes, time.Since(start), rec.Header().Get("Content-Encoding"), cacheHit)
+	}()
+	w = rec
+
 	staticFS, err := fs.Sub(m.config.StaticFS, m.config.StaticRoot)
 	if err != nil {
-		if m.config.Debug {
-			m.config.Logger.Printf("Error creating sub-filesystem: %s", err)
-		}
+		m.debugf(r, "Error creating sub-filesystem: %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if len(m.config.Overlays) > 0 {
+		staticFS = NewLayeredFS(append(append([]fs.FS{}, m.config.Overlays...), staticFS)...)
+	}
+
+	relPath := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, m.config.StaticFilePrefix), "/")
+	if relPath == "" {
+		relPath = m.indexFile()
+	}
+
+	if status := m.blockedStatus(relPath); status != 0 {
+		m.debugf(r, "Blocking request for %s with status %d", relPath, status)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if m.config.IndexTransform.enabled() && m.config.IndexTransform.matches(relPath) {
+		if m.serveIndexTransform(w, r, staticFS, relPath) {
+			return
+		}
+	}
+
+	if m.config.SPAMode {
+		_, statErr := fs.Stat(staticFS, relPath)
+		if errors.Is(statErr, fs.ErrNotExist) {
+			accept := r.Header.Get("Accept")
+			if !isAssetPath(relPath) || strings.Contains(accept, "text/html") {
+				indexPath := m.indexFile()
+				if m.config.IndexTransform.enabled() && m.config.IndexTransform.matches(indexPath) {
+					if m.serveIndexTransform(w, r, staticFS, indexPath) {
+						return
+					}
+				}
+				m.serveIndex(w, r, staticFS)
+				return
+			}
+		}
+	}
+
 	// Set Cache-Control header if CacheDuration is specified
 	if m.config.CacheDuration > 0 {
 		cacheControlValue := fmt.Sprintf("public, max-age=%d", int(m.config.CacheDuration.Seconds()))
 		w.Header().Set("Cache-Control", cacheControlValue)
 	}
 
+	if m.servePrecompressed(w, r, staticFS, relPath) {
+		cacheHit = true
+		return
+	}
+
+	if m.serveETag(w, r, staticFS, relPath) {
+		cacheHit = true
+		return
+	}
+
 	fileServer := http.FileServer(http.FS(staticFS))
 	http.StripPrefix(m.config.StaticFilePrefix, fileServer).ServeHTTP(w, r)
 }
+
+// servePrecompressed looks for a sibling file carrying one of the configured Precompressed
+// encodings (in priority order) and, if the client's Accept-Encoding allows it, serves that
+// file directly with Content-Encoding set and the original Content-Type preserved. It reports
+// whether it served the request, so the caller can fall back to the uncompressed file on miss.
+func (m *StaticMiddleware) servePrecompressed(w http.ResponseWriter, r *http.Request, staticFS fs.FS, relPath string) bool {
+	if len(m.config.Precompressed) == 0 {
+		return false
+	}
+
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	if acceptEncoding == "" {
+		return false
+	}
+
+	for _, encoding := range m.config.Precompressed {
+		suffix, ok := precompressedSuffixes[encoding]
+		if !ok || !acceptsEncoding(acceptEncoding, encoding) {
+			continue
+		}
+
+		compressedPath := relPath + suffix
+		info, err := fs.Stat(staticFS, compressedPath)
+		if err != nil {
+			continue
+		}
+
+		f, err := staticFS.Open(compressedPath)
+		if err != nil {
+			continue
+		}
+
+		if contentType := mime.TypeByExtension(path.Ext(relPath)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		_, _ = io.Copy(w, f)
+		_ = f.Close()
+		return true
+	}
+
+	return false
+}
+
+// etagEntry is the cached (etag, size, contentType, data) tuple for a single path.
+type etagEntry struct {
+	etag        string
+	size        int64
+	contentType string
+	data        []byte
+}
+
+// etagCache caches etagEntry tuples keyed by path, enforcing a total byte budget via
+// least-recently-used eviction when maxBytes is positive.
+type etagCache struct {
+	entries sync.Map
+
+	mu       sync.Mutex
+	order    []string
+	total    int64
+	maxBytes int64
+}
+
+func newEtagCache(maxBytes int64) *etagCache {
+	return &etagCache{maxBytes: maxBytes}
+}
+
+func (c *etagCache) get(path string) (*etagEntry, bool) {
+	v, ok := c.entries.Load(path)
+	if !ok {
+		return nil, false
+	}
+	c.touch(path)
+	return v.(*etagEntry), true
+}
+
+// put stores entry, evicting least-recently-used entries until the cache is back under
+// maxBytes. It reports whether entry was actually cached — if entry.size alone exceeds
+// maxBytes, caching it would just evict it again immediately, so it is left out of the cache
+// entirely (any existing entry for path is still removed, since it's stale).
+func (c *etagCache) put(path string, entry *etagEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.entries.Load(path); ok {
+		c.total -= existing.(*etagEntry).size
+		c.removeFromOrder(path)
+		c.entries.Delete(path)
+	}
+
+	if c.maxBytes > 0 && entry.size > c.maxBytes {
+		return false
+	}
+
+	c.entries.Store(path, entry)
+	c.order = append(c.order, path)
+	c.total += entry.size
+
+	for c.maxBytes > 0 && c.total > c.maxBytes && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		if v, ok := c.entries.LoadAndDelete(oldest); ok {
+			c.total -= v.(*etagEntry).size
+		}
+	}
+
+	return true
+}
+
+// touch moves path to the most-recently-used end of the eviction order.
+func (c *etagCache) touch(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeFromOrder(path)
+	c.order = append(c.order, path)
+}
+
+// removeFromOrder removes path from the eviction order. Callers must hold c.mu.
+func (c *etagCache) removeFromOrder(path string) {
+	for i, p := range c.order {
+		if p == path {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// serveETag serves relPath from the etag cache (populating it on first access), honoring
+// If-None-Match with a 304. It reports whether it handled the request, so the caller can fall
+// back to the standard file server for directories or files it can't read.
+func (m *StaticMiddleware) serveETag(w http.ResponseWriter, r *http.Request, staticFS fs.FS, relPath string) bool {
+	if m.etagCache == nil {
+		return false
+	}
+
+	info, err := fs.Stat(staticFS, relPath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	entry, ok := m.etagCache.get(relPath)
+	if !ok {
+		data, err := fs.ReadFile(staticFS, relPath)
+		if err != nil {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		entry = &etagEntry{
+			etag:        fmt.Sprintf(`"%x-%d"`, sum[:16], len(data)),
+			size:        int64(len(data)),
+			contentType: mime.TypeByExtension(path.Ext(relPath)),
+			data:        data,
+		}
+		if !m.etagCache.put(relPath, entry) {
+			m.debugf(r, "Not caching %s: %d bytes exceeds MaxCachedBytes %d", relPath, entry.size, m.config.MaxCachedBytes)
+		}
+	}
+
+	w.Header().Set("ETag", entry.etag)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == entry.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	if entry.contentType != "" {
+		w.Header().Set("Content-Type", entry.contentType)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.size, 10))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(entry.data)
+	return true
+}
+
+// indexFile returns the configured IndexFile, defaulting to "index.html".
+func (m *StaticMiddleware) indexFile() string {
+	if m.config.IndexFile != "" {
+		return m.config.IndexFile
+	}
+	return "index.html"
+}
+
+// serveIndex serves the SPA shell, rewriting its <base href="..."> when BasePath is set and
+// forcing a no-cache response so clients always pick up a fresh shell even when CacheDuration
+// is configured for the rest of the build.
+func (m *StaticMiddleware) serveIndex(w http.ResponseWriter, r *http.Request, staticFS fs.FS) {
+	data, err := fs.ReadFile(staticFS, m.indexFile())
+	if err != nil {
+		m.debugf(r, "Error reading index file: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if m.config.BasePath != "" {
+		data = baseHrefPattern.ReplaceAll(data, []byte(fmt.Sprintf(`<base href="%s">`, m.config.BasePath)))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// indexTransformFuncs provides the "js" template func used to embed values as inline
+// JavaScript, e.g. "window.__CONFIG__ = {{ .Config | js }}".
+var indexTransformFuncs = template.FuncMap{
+	"js": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+// indexTemplateData is the root object exposed to an IndexTransform template.
+type indexTemplateData struct {
+	Config any
+	Nonce  string
+}
+
+// cachedTemplate is a parsed template keyed by the source file's mtime and size, so a change to
+// the underlying file invalidates the cache without needing a watcher.
+type cachedTemplate struct {
+	modTime time.Time
+	size    int64
+	tmpl    *template.Template
+}
+
+// templateCache caches one parsed template per path.
+type templateCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedTemplate
+}
+
+func newTemplateCache() *templateCache {
+	return &templateCache{entries: make(map[string]cachedTemplate)}
+}
+
+// generateNonce returns a base64-encoded 16-byte random nonce for use in a CSP header and
+// template.
+func generateNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// indexTemplate returns the parsed template for relPath, reusing the cached parse when the
+// file's mtime and size haven't changed since it was last read.
+func (m *StaticMiddleware) indexTemplate(staticFS fs.FS, relPath string, info fs.FileInfo) (*template.Template, error) {
+	m.templateCache.mu.Lock()
+	cached, ok := m.templateCache.entries[relPath]
+	m.templateCache.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.tmpl, nil
+	}
+
+	data, err := fs.ReadFile(staticFS, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(relPath).Funcs(indexTransformFuncs).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	m.templateCache.mu.Lock()
+	m.templateCache.entries[relPath] = cachedTemplate{modTime: info.ModTime(), size: info.Size(), tmpl: tmpl}
+	m.templateCache.mu.Unlock()
+
+	return tmpl, nil
+}
+
+// serveIndexTransform renders relPath through the IndexTransform template pipeline: a fresh CSP
+// nonce is generated, TemplateData (if set) supplies .Config, and the cached parsed template is
+// executed against the result. Like serveIndex, it rewrites <base href="..."> when BasePath is
+// set. If CSPPolicy is set, the rendered policy is emitted as the Content-Security-Policy
+// header. It reports whether it served the request, so the caller can fall back when relPath
+// doesn't exist.
+func (m *StaticMiddleware) serveIndexTransform(w http.ResponseWriter, r *http.Request, staticFS fs.FS, relPath string) bool {
+	info, err := fs.Stat(staticFS, relPath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	tmpl, err := m.indexTemplate(staticFS, relPath, info)
+	if err != nil {
+		m.debugf(r, "Error parsing index template %s: %s", relPath, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	nonce, err := generateNonce()
+	if err != nil {
+		m.debugf(r, "Error generating CSP nonce: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	data := indexTemplateData{Nonce: nonce}
+	if m.config.IndexTransform.TemplateData != nil {
+		data.Config = m.config.IndexTransform.TemplateData(r)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		m.debugf(r, "Error executing index template %s: %s", relPath, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	rendered := buf.Bytes()
+	if m.config.BasePath != "" {
+		rendered = baseHrefPattern.ReplaceAll(rendered, []byte(fmt.Sprintf(`<base href="%s">`, m.config.BasePath)))
+	}
+
+	if m.config.IndexTransform.CSPPolicy != "" {
+		w.Header().Set("Content-Security-Policy", strings.ReplaceAll(m.config.IndexTransform.CSPPolicy, "{nonce}", nonce))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(rendered)
+	return true
+}
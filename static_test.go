@@ -1,9 +1,19 @@
 package chistaticmiddleware
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -39,13 +49,246 @@ func TestNewStaticMiddleware(t *testing.T) {
 				t.Errorf("NewStaticMiddleware().Debug = %v, want %v", got.config.Debug, tt.wantDebug)
 			}
 
-			if tt.wantDebug && got.config.Logger == nil {
-				t.Errorf("Expected logger to be set when Debug is true")
+			if tt.wantDebug && got.config.SlogLogger == nil {
+				t.Errorf("Expected SlogLogger to default to slog.Default() when Debug is true")
 			}
 		})
 	}
 }
 
+// TestLayeredFS tests that LayeredFS prefers earlier layers and falls back to later ones.
+func TestLayeredFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("dev-build"), 0o644); err != nil {
+		t.Fatalf("Failed to write overlay file: %v", err)
+	}
+
+	embedded := fstest.MapFS{
+		"app.js":    &fstest.MapFile{Data: []byte("prod-build")},
+		"style.css": &fstest.MapFile{Data: []byte("body {}")},
+	}
+
+	layered := NewLayeredFS(os.DirFS(dir), embedded)
+
+	data, err := fs.ReadFile(layered, "app.js")
+	if err != nil {
+		t.Fatalf("Failed to read overlaid file: %v", err)
+	}
+	if string(data) != "dev-build" {
+		t.Errorf("Expected overlay to take precedence, got %q", data)
+	}
+
+	data, err = fs.ReadFile(layered, "style.css")
+	if err != nil {
+		t.Fatalf("Failed to read fallback file: %v", err)
+	}
+	if string(data) != "body {}" {
+		t.Errorf("Expected fallback file contents, got %q", data)
+	}
+
+	if _, err := fs.ReadFile(layered, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for missing file, got %v", err)
+	}
+}
+
+// TestHandlerOverlays tests that Overlays let an on-disk file override the embedded one served
+// through the middleware.
+func TestHandlerOverlays(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("dev-build"), 0o644); err != nil {
+		t.Fatalf("Failed to write overlay file: %v", err)
+	}
+
+	mockFS := fstest.MapFS{
+		"static/app.js": &fstest.MapFile{Data: []byte("prod-build")},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		Overlays:         []fs.FS{os.DirFS(dir)},
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/static/app.js")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "dev-build" {
+		t.Errorf("Expected overlay contents to win, got %q", body)
+	}
+}
+
+// TestHandlerDev tests that NewStaticMiddlewareDev prepends devDir ahead of both the configured
+// Overlays and StaticFS, so on-disk edits take precedence over everything else.
+func TestHandlerDev(t *testing.T) {
+	devDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(devDir, "app.js"), []byte("dev-build"), 0o644); err != nil {
+		t.Fatalf("Failed to write dev overlay file: %v", err)
+	}
+
+	otherOverlayDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(otherOverlayDir, "app.js"), []byte("other-overlay"), 0o644); err != nil {
+		t.Fatalf("Failed to write other overlay file: %v", err)
+	}
+
+	mockFS := fstest.MapFS{
+		"static/app.js": &fstest.MapFile{Data: []byte("prod-build")},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		Overlays:         []fs.FS{os.DirFS(otherOverlayDir)},
+	}
+	staticMiddleware := NewStaticMiddlewareDev(staticConfig, devDir)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/static/app.js")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "dev-build" {
+		t.Errorf("Expected devDir to take precedence over pre-existing Overlays, got %q", body)
+	}
+}
+
+// TestHandlerHidden tests that Hidden patterns and DotfilePolicy keep matching paths out of
+// production responses.
+func TestHandlerHidden(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/app.js":     &fstest.MapFile{Data: []byte("console.log(1)")},
+		"static/app.js.map": &fstest.MapFile{Data: []byte("{}")},
+		"static/.env":       &fstest.MapFile{Data: []byte("SECRET=1")},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		Hidden:           []string{"*.map"},
+		DotfilePolicy:    DotfilePolicyDeny,
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/static/app.js.map")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for hidden pattern, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(ts.URL + "/static/.env")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status code 403 for denied dotfile, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(ts.URL + "/static/app.js")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 for unmatched file, got %d", res.StatusCode)
+	}
+}
+
+// TestHandlerSlogLogger tests that SlogLogger receives a structured record describing how a
+// request was served.
+func TestHandlerSlogLogger(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/testfile.css": &fstest.MapFile{
+			Data: []byte("body {}"),
+		},
+	}
+
+	var records []slog.Record
+	logger := slog.New(&recordingHandler{records: &records})
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		Debug:            true,
+		SlogLogger:       logger,
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/static/testfile.css")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	var found bool
+	for _, rec := range records {
+		if rec.Message == "served static file" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a 'served static file' record, got %v", records)
+	}
+}
+
+// recordingHandler is a minimal slog.Handler that appends every record it receives, for
+// asserting on structured log output in tests.
+type recordingHandler struct {
+	records *[]slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	*h.records = append(*h.records, record)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs(_ []slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(_ string) slog.Handler { return h }
+
 // TestHandler tests the handling of requests by the StaticMiddleware.
 func TestHandler(t *testing.T) {
 	// Create a mock file system using fstest.MapFS
@@ -144,6 +387,397 @@ func TestHandler404(t *testing.T) {
 	}
 }
 
+// TestHandlerETag tests that ETag caches a strong ETag for embedded files and honors
+// If-None-Match with a 304.
+func TestHandlerETag(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/testfile.css": &fstest.MapFile{
+			Data: []byte("body {}"),
+		},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		ETag:             true,
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/static/testfile.css")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected ETag header to be set")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/static/testfile.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status code 304, got %d", res.StatusCode)
+	}
+}
+
+// TestEtagCacheEviction tests that etagCache evicts least-recently-used entries once maxBytes
+// is exceeded, and that a single entry larger than maxBytes is left uncached rather than being
+// stored and immediately evicted.
+func TestEtagCacheEviction(t *testing.T) {
+	c := newEtagCache(10)
+
+	if !c.put("a", &etagEntry{size: 4}) {
+		t.Fatalf("Expected entry 'a' to be cached")
+	}
+	if !c.put("b", &etagEntry{size: 4}) {
+		t.Fatalf("Expected entry 'b' to be cached")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("Expected entry 'a' to still be cached")
+	}
+
+	// Adding 'c' pushes the total to 12, over the 10-byte budget, so the least-recently-used
+	// entry ('b', since 'a' was just touched by the get above) is evicted.
+	if !c.put("c", &etagEntry{size: 4}) {
+		t.Fatalf("Expected entry 'c' to be cached")
+	}
+	if _, ok := c.get("b"); ok {
+		t.Errorf("Expected entry 'b' to have been evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("Expected entry 'a' to survive eviction")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Errorf("Expected entry 'c' to survive eviction")
+	}
+
+	// A single entry larger than maxBytes can never fit, so it should be left uncached rather
+	// than stored and immediately evicted, which would silently disable caching for everything
+	// else too.
+	if c.put("huge", &etagEntry{size: 20}) {
+		t.Errorf("Expected oversized entry not to be cached")
+	}
+	if _, ok := c.get("huge"); ok {
+		t.Errorf("Expected oversized entry to be absent from the cache")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("Expected existing entries to survive a failed put of an oversized entry")
+	}
+}
+
+// TestHandlerPrecompressed tests that a precompressed sibling file is served with the
+// matching Content-Encoding when the client accepts it, and that the uncompressed file is
+// served otherwise.
+func TestHandlerPrecompressed(t *testing.T) {
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	if _, err := gw.Write([]byte("console.log('plain')")); err != nil {
+		t.Fatalf("Failed to write gzip fixture: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("Failed to close gzip writer: %v", err)
+	}
+
+	mockFS := fstest.MapFS{
+		"static/app.js": &fstest.MapFile{
+			Data: []byte("console.log('plain')"),
+		},
+		"static/app.js.gz": &fstest.MapFile{
+			Data: gzipped.Bytes(),
+		},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		Precompressed:    []string{"br", "gzip"},
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/static/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", res.StatusCode)
+	}
+	if ce := res.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Expected Content-Encoding 'gzip', got %q", ce)
+	}
+	if vary := res.Header.Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Expected Vary 'Accept-Encoding', got %q", vary)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != gzipped.String() {
+		t.Errorf("Expected gzipped file contents, got %q", body)
+	}
+
+	// Without a matching Accept-Encoding, falls back to the uncompressed file. Use a client
+	// with compression disabled so the transport doesn't add its own Accept-Encoding: gzip
+	// and try to transparently decode whatever the server returns.
+	noCompressClient := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	res, err = noCompressClient.Get(ts.URL + "/static/app.js")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ = io.ReadAll(res.Body)
+	if string(body) != "console.log('plain')" {
+		t.Errorf("Expected plain file contents, got %q", body)
+	}
+}
+
+// TestHandlerPrecompressedQValueZero tests that an encoding explicitly disallowed with q=0 is
+// skipped even though its name still appears in the Accept-Encoding header.
+func TestHandlerPrecompressedQValueZero(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/app.js": &fstest.MapFile{
+			Data: []byte("console.log('plain')"),
+		},
+		"static/app.js.br": &fstest.MapFile{
+			Data: []byte("br-bytes"),
+		},
+		"static/app.js.gz": &fstest.MapFile{
+			Data: []byte("gzip-bytes"),
+		},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		Precompressed:    []string{"br", "gzip"},
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/static/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br;q=0, gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if ce := res.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Expected Content-Encoding 'gzip' since br is disallowed by q=0, got %q", ce)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "gzip-bytes" {
+		t.Errorf("Expected gzip file contents, got %q", body)
+	}
+}
+
+// TestHandlerSPAMode tests that SPAMode serves the index file for unresolved page routes
+// while still returning 404 for missing assets.
+func TestHandlerSPAMode(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/index.html": &fstest.MapFile{
+			Data: []byte(`<html><head><base href="/"></head><body>app</body></html>`),
+		},
+		"static/assets/app.js": &fstest.MapFile{
+			Data: []byte("console.log('app')"),
+		},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		SPAMode:          true,
+		BasePath:         "/app/",
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Deep link route falls back to the rewritten index shell.
+	res, err := http.Get(ts.URL + "/static/dashboard/settings")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", res.StatusCode)
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Expected Cache-Control 'no-cache', got %q", cc)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), `<base href="/app/">`) {
+		t.Errorf("Expected rewritten base href, got %q", body)
+	}
+
+	// Missing asset still 404s so bundler errors remain visible.
+	res, err = http.Get(ts.URL + "/static/assets/missing.js")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404, got %d", res.StatusCode)
+	}
+
+	// A missing file with an extension outside any hand-picked allowlist (e.g. a bundler
+	// emitting a PDF) still 404s rather than falling back to the SPA shell.
+	res, err = http.Get(ts.URL + "/static/assets/report.pdf")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for missing .pdf asset, got %d", res.StatusCode)
+	}
+}
+
+// TestHandlerIndexTransform tests that IndexTransform renders index.html as a template,
+// injecting TemplateData as .Config and a per-request CSP nonce as both .Nonce and the
+// Content-Security-Policy header.
+func TestHandlerIndexTransform(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/index.html": &fstest.MapFile{
+			Data: []byte(`<html><head><script nonce="{{ .Nonce }}">window.__CONFIG__ = {{ .Config | js }}</script></head><body>app</body></html>`),
+		},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		IndexTransform: IndexTransformConfig{
+			TemplateData: func(r *http.Request) any {
+				return map[string]string{"env": "staging"}
+			},
+			CSPPolicy: "default-src 'self'; script-src 'nonce-{nonce}'",
+		},
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/static/index.html")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", res.StatusCode)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), `"env":"staging"`) {
+		t.Errorf("Expected injected config in body, got %q", body)
+	}
+
+	csp := res.Header.Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'nonce-") {
+		t.Errorf("Expected CSP header with nonce, got %q", csp)
+	}
+	nonce := strings.TrimSuffix(strings.TrimPrefix(csp, "default-src 'self'; script-src 'nonce-"), "'")
+	if nonce == "" || !strings.Contains(string(body), nonce) {
+		t.Errorf("Expected body to embed the same nonce as the CSP header, got body %q csp %q", body, csp)
+	}
+}
+
+// TestHandlerIndexTransformWithBasePath tests that the <base href> rewrite from BasePath still
+// applies when IndexTransform is also serving the SPA shell through the template pipeline.
+func TestHandlerIndexTransformWithBasePath(t *testing.T) {
+	mockFS := fstest.MapFS{
+		"static/index.html": &fstest.MapFile{
+			Data: []byte(`<html><head><base href="/"><script nonce="{{ .Nonce }}">window.__CONFIG__ = {{ .Config | js }}</script></head><body>app</body></html>`),
+		},
+	}
+
+	r := chi.NewRouter()
+	staticConfig := Config{
+		StaticFS:         mockFS,
+		StaticRoot:       "static",
+		StaticFilePrefix: "/static",
+		SPAMode:          true,
+		BasePath:         "/app/",
+		IndexTransform: IndexTransformConfig{
+			TemplateData: func(r *http.Request) any {
+				return map[string]string{"env": "staging"}
+			},
+		},
+	}
+	staticMiddleware := NewStaticMiddleware(staticConfig)
+
+	r.Use(staticMiddleware.Handler())
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Deep link route falls back to the templated index shell, which should still carry the
+	// rewritten base href alongside the injected config.
+	res, err := http.Get(ts.URL + "/static/dashboard/settings")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", res.StatusCode)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), `<base href="/app/">`) {
+		t.Errorf("Expected rewritten base href, got %q", body)
+	}
+	if !strings.Contains(string(body), `"env":"staging"`) {
+		t.Errorf("Expected injected config in body, got %q", body)
+	}
+}
+
 // TestHandlerError tests the behavior of the Handler function when fs.Sub(m.config.StaticFS, m.config.StaticRoot) raises an error.
 func TestHandlerError(t *testing.T) {
 	// Create a mock file system using fstest.MapFS